@@ -1,303 +1,1105 @@
-// See original Lispy at http://norvig.com/lispy.html
-
-package main
-
-import (
-    "bufio"
-    "fmt"
-    "os"
-    "regexp"
-    "strings"
-)
-
-// Read a Scheme expression from a string.
-func parse(program string) interface{} {
-    tokens := tokenize(program)
-    s, _ := readFromTokens(tokens)
-    return s
-}
-
-// Convert a string of characters into a list of tokens.
-func tokenize(chars string) []string {
-    s := strings.Replace(chars, "(", " ( ", -1)
-    s = strings.Replace(s, ")", " ) ", -1)
-    s = strings.TrimSpace(s)
-    return regexp.MustCompile("\\s+").Split(s, -1)
-}
-
-func pop(ts []string) (string, []string) {
-    if len(ts) == 0 {
-	return "", make([]string, 0)
-    } else {
-	return ts[0], ts[1:]
-    }
-}
-
-// Read an expression from a sequence of tokens.
-func readFromTokens(tokens []string) (interface{}, []string) {
-    if len(tokens) == 0 {
-        panic("unexpected EOF while reading")
-    }
-    var token string
-    token, tokens = pop(tokens)
-    if "(" == token {
-	l := make([]interface{}, 0)
-        for tokens[0] != ")" {
-	    var s interface{}
-	    s, tokens = readFromTokens(tokens)
-            l = append(l, s)
-	}
-        _, tokens = pop(tokens) // pop off ")"
-        return l, tokens
-    } else if ")" == token {
-        panic("unexpected )")
-    } else {
-        return atom(token), tokens
-    }
-}
-
-// Numbers become numbers; every other token is a symbol.
-func atom(token string) interface{} {
-    var n int
-    _, err := fmt.Sscan(token, &n)
-    if err == nil {
-	return n
-    } else {
-	return token
-    }
-}
-
-// A user-defined Scheme procedure.
-type Procedure struct {
-    parms []string
-    body interface{}
-    env *Env
-}
-
-func newProcedure(parms interface{}, body interface{}, env *Env) *Procedure {
-    strs := toStrings(parms)
-    return &Procedure{strs, body, env}
-}
-
-func toStrings(parms interface{}) []string {
-    if l, ok := parms.([]interface{}); ok {
-	strs := make([]string, 0)
-	for i := 0; i < len(l); i++ {
-	    if str, ok := isSymbol(l[i]); ok {
-		strs = append(strs, str)
-	    } else {
-		panic("parms needs symbols")
-	    }
-	}
-	return strs
-    } else {
-	panic("parms must be a list")
-    }
-}
-
-func (proc *Procedure) Call(args []interface{}) interface{} {
-    return eval(proc.body, newEnv(proc.parms, args, proc.env))
-}
-
-// An environment: a map of ["var":val] pairs, with an outer Env.
-type Env struct {
-    inner map[string]interface{}
-    outer *Env
-}
-
-func newEmptyEnv() *Env {
-    return &Env{make(map[string]interface{}, 0), nil}
-}
-
-func newEnv(parms []string, args []interface{}, outer *Env) *Env{
-    env := newEmptyEnv()
-    for i := 0; i < len(parms); i++ {
-	env.inner[parms[i]] = args[i]
-    }
-    env.outer = outer
-    return env
-}
-
-// Find the innermost Env where var appears.
-func (env *Env) Find(v string) *Env {
-    if env.inner[v] != nil || env.outer == nil {
-	return env
-    }
-    return env.outer.Find(v)
-}
-
-// An environment with some Scheme standard procedures.
-func standardEnv() *Env {
-    env := newEmptyEnv()
-    env.inner["false"] = false
-    env.inner["true"] = true
-    env.inner["+"] = func(args []interface{}) interface{} {
-	n, ok1 := args[0].(int)
-	m, ok2 := args[1].(int)
-	if !ok1 || !ok2 {
-	    panic("+ needs numbers")
-	}
-	return n + m
-    }
-    return env
-}
-
-var globalEnv = standardEnv()
-
-// Evaluate an expression in an environment.
-func eval(x interface{}, env *Env) interface{} {
-    if str, ok := isSymbol(x); ok { // variable reference
-	return env.Find(str).inner[str]
-    }
-    l, ok := isList(x)
-    if !ok { // constant literal
-	return x
-    }
-    if len(l) == 0 {
-	panic("empty list")
-    }
-    if str, ok := isSymbol(l[0]); ok {
-	switch (str) {
-	case "quote":
-	    // (quote exp)
-	    return l[1]
-	case "if":
-	    //  (if test conseq alt)
-	    test, conseq, alt := l[1], l[2], l[3]
-	    r := eval(test, env)
-	    if b, ok := isFalse(r); ok && b {
-		return eval(alt, env)
-	    } else {
-		return eval(conseq, env)
-	    }
-	case "define":
-	    // (define var exp)
-	    car, cdr := l[1], l[2]
-	    if str, ok = isSymbol(car); ok {
-		env.inner[str] = eval(cdr, env)
-		return env.inner[str]
-	    } else {
-		panic("define needs a symbol")
-	    }
-	case "set!":
-	    // (set! var exp)
-	    v, exp := l[1], l[2]
-	    if str, ok := isSymbol(v); ok {
-		e := env.Find(str)
-		e.inner[str] = eval(exp, env)
-		return e.inner[str]
-	    } else {
-		panic("set! needs a symbol")
-	    }
-	case "lambda":
-	    // (lambda (var...) body)
-	    parms, body := l[1], l[2]
-	    return newProcedure(parms, body, env)
-	}
-    }
-    // (proc arg...)
-    car := eval(l[0], env)
-    args := makeArgs(l[1:], env)
-    if prim, ok := isPrim(car); ok {
-	return prim(args)
-    }
-    if proc, ok := car.(*Procedure); ok {
-	return proc.Call(args)
-    }
-    return nil
-}
-
-func makeArgs(l []interface{}, env *Env) []interface{} {
-    args := make([]interface{}, 0)
-    for i := 0; i < len(l); i++ {
-	args = append(args, eval(l[i], env))
-    }
-    return args
-}
-
-func isSymbol(x interface{}) (string, bool) {
-    s, ok := x.(string)
-    return s, ok
-}
-
-func isList(x interface{}) ([]interface{}, bool) {
-    l, ok := x.([]interface{})
-    return l, ok
-}
-
-func isFalse(x interface{}) (bool, bool) {
-    b, ok := x.(bool)
-    return !b, ok
-}
-
-func isPrim(x interface{}) (func([]interface{})interface{}, bool) {
-    prim, ok := x.(func([]interface{})interface{})
-    return prim, ok
-}
-
-// A prompt-read-eval-print loop.
-func repl() {
-    prompt := "lis.go> "
-    in := bufio.NewReader(os.Stdin)
-    for {
-	fmt.Printf("%s", prompt)
-	line, _ := in.ReadString('\n')
-	val := eval(parse(line), globalEnv)
-        if val != nil {
-            fmt.Printf("%s\n", schemestr(val))
-	}
-    }
-}
-
-// Convert a Python object back into a Scheme-readable string.
-func schemestr(exp interface{}) string {
-    if l, ok := isList(exp); ok {
-	s := make([]string, 0)
-	for i := 0; i < len(l); i++ {
-	    s = append(s, schemestr(l[i]))
-	}
-        return "(" + strings.Join(s, " ") + ")"
-    } else {
-	return fmt.Sprintf("%d", exp)
-    }
-}
-
-func dump(s string) {
-    d(parse(s))
-}
-
-func d(a interface{}) {
-    fmt.Printf("%s\n", a)
-}
-
-func e(s string) {
-    d(eval(parse(s), globalEnv))
-}
-
-func t() {
-    dump("1")
-    dump("a")
-    dump("()")
-    dump("(1)")
-    dump("(1 2)")
-    dump("(1 (2))")
-    str, ok := isSymbol("a")
-    fmt.Printf("%s %s\n", str, ok)
-    str, ok = isSymbol(1)
-    fmt.Printf("%s %s\n", str, ok)
-    e("(quote (b))")
-    e("(if false 1 2)")
-    e("(if true 1 2)")
-    e("(define a 10)")
-    e("a")
-    e("(+ 1 2)")
-    e("(set! a 20)")
-    e("a")
-    e("(define x2 (lambda (a) (+ a a)))")
-    e("(x2 100)")
-}
-
-func main() {
-    repl()
-}
-
+// See original Lispy at http://norvig.com/lispy.html
+
+package main
+
+import (
+    "bufio"
+    "fmt"
+    "math"
+    "os"
+    "regexp"
+    "strconv"
+    "strings"
+    "sync"
+)
+
+// A cons cell, the basic building block of Scheme lists. Cdr is an
+// interface{} rather than *Cell so that improper (dotted) lists can be
+// represented, the way the Nukata Lisp interpreter does it.
+type Cell struct {
+    Car interface{}
+    Cdr interface{}
+}
+
+// Nil is the empty list, represented as a nil *Cell.
+var Nil = (*Cell)(nil)
+
+// list builds a proper list out of its arguments.
+func list(args ...interface{}) *Cell {
+    result := Nil
+    for i := len(args) - 1; i >= 0; i-- {
+	result = &Cell{args[i], result}
+    }
+    return result
+}
+
+// Read a Scheme expression from a string.
+func parse(program string) interface{} {
+    tokens := tokenize(program)
+    s, _ := readFromTokens(tokens)
+    return s
+}
+
+// Convert a string of characters into a list of tokens. The reader
+// macros ' ` , and ,@ are split off as their own tokens too.
+func tokenize(chars string) []string {
+    s := strings.Replace(chars, "(", " ( ", -1)
+    s = strings.Replace(s, ")", " ) ", -1)
+    s = strings.Replace(s, "'", " ' ", -1)
+    s = strings.Replace(s, "`", " ` ", -1)
+    s = strings.Replace(s, ",@", "\x00", -1) // placeholder so "," below leaves it intact
+    s = strings.Replace(s, ",", " , ", -1)
+    s = strings.Replace(s, "\x00", " ,@ ", -1)
+    s = strings.TrimSpace(s)
+    return regexp.MustCompile("\\s+").Split(s, -1)
+}
+
+func pop(ts []string) (string, []string) {
+    if len(ts) == 0 {
+	return "", make([]string, 0)
+    } else {
+	return ts[0], ts[1:]
+    }
+}
+
+// Read an expression from a sequence of tokens.
+func readFromTokens(tokens []string) (interface{}, []string) {
+    if len(tokens) == 0 {
+        panic("unexpected EOF while reading")
+    }
+    var token string
+    token, tokens = pop(tokens)
+    switch token {
+    case "(":
+	return readList(tokens)
+    case ")":
+        panic("unexpected )")
+    case "'":
+	return readQuoted("quote", tokens)
+    case "`":
+	return readQuoted("quasiquote", tokens)
+    case ",":
+	return readQuoted("unquote", tokens)
+    case ",@":
+	return readQuoted("unquote-splicing", tokens)
+    default:
+        return atom(token), tokens
+    }
+}
+
+// readQuoted reads the expression a reader macro applies to and wraps
+// it as (sym expr), e.g. 'x becomes (quote x).
+func readQuoted(sym string, tokens []string) (interface{}, []string) {
+    var x interface{}
+    x, tokens = readFromTokens(tokens)
+    return &Cell{sym, &Cell{x, Nil}}, tokens
+}
+
+// Read the contents of a list (after the opening "(" has been consumed),
+// understanding the dotted-pair notation "(a . b)".
+func readList(tokens []string) (interface{}, []string) {
+    if len(tokens) == 0 {
+	panic("unexpected EOF while reading")
+    }
+    if tokens[0] == ")" {
+	_, tokens = pop(tokens)
+	return Nil, tokens
+    }
+    if tokens[0] == "." {
+	_, tokens = pop(tokens)
+	var tail interface{}
+	tail, tokens = readFromTokens(tokens)
+	if len(tokens) == 0 || tokens[0] != ")" {
+	    panic("expected ) after dotted tail")
+	}
+	_, tokens = pop(tokens)
+	return tail, tokens
+    }
+    var car interface{}
+    car, tokens = readFromTokens(tokens)
+    var cdr interface{}
+    cdr, tokens = readList(tokens)
+    return &Cell{car, cdr}, tokens
+}
+
+// Numbers become numbers (int64, or float64 for decimals/scientific
+// notation like 1.5 or 1e3); every other token is a symbol.
+func atom(token string) interface{} {
+    if n, err := strconv.ParseInt(token, 10, 64); err == nil {
+	return n
+    }
+    if f, err := strconv.ParseFloat(token, 64); err == nil {
+	return f
+    }
+    return token
+}
+
+// A user-defined Scheme procedure. parms is either a proper list of
+// symbols, a dotted list (a b . rest), or a single symbol binding all
+// the arguments as a rest-arg.
+type Procedure struct {
+    parms interface{}
+    body interface{}
+    env *Env
+}
+
+func newProcedure(parms interface{}, body interface{}, env *Env) *Procedure {
+    return &Procedure{parms, body, env}
+}
+
+// A macro: like a Procedure, but its arguments are passed unevaluated
+// and the form it produces is evaluated again in the caller's env.
+type Macro struct {
+    parms interface{}
+    body interface{}
+    env *Env
+}
+
+func newMacro(parms interface{}, body interface{}, env *Env) *Macro {
+    return &Macro{parms, body, env}
+}
+
+// Expand runs the macro's body against its unevaluated arguments,
+// producing the form to evaluate in the caller's environment.
+func (m *Macro) Expand(args *Cell) interface{} {
+    return eval(m.body, newEnv(m.parms, cellToSlice(args), m.env))
+}
+
+// cellToSlice collects the elements of a proper list without evaluating
+// them, as needed to pass unevaluated macro arguments to newEnv.
+func cellToSlice(c *Cell) []interface{} {
+    s := make([]interface{}, 0)
+    for c != Nil {
+	s = append(s, c.Car)
+	c = rest(c.Cdr)
+    }
+    return s
+}
+
+// A future: the handle returned by (future exp), evaluating exp in its
+// own goroutine. once guards ch so repeated force calls reuse the
+// cached result instead of blocking on an already-drained channel.
+type Future struct {
+    once sync.Once
+    ch chan interface{}
+    result interface{}
+}
+
+// panicValue wraps a panic raised while evaluating a future's
+// expression, so Force can re-raise it in the forcing goroutine.
+type panicValue struct {
+    value interface{}
+}
+
+func newFuture(exp interface{}, env *Env) *Future {
+    f := &Future{ch: make(chan interface{}, 1)}
+    go func() {
+	defer func() {
+	    if r := recover(); r != nil {
+		f.ch <- panicValue{r}
+	    }
+	}()
+	f.ch <- eval(exp, env)
+    }()
+    return f
+}
+
+// Force blocks until the future's goroutine has produced a result (or
+// panicked), then returns it, re-raising a propagated panic.
+func (f *Future) Force() interface{} {
+    f.once.Do(func() {
+	f.result = <-f.ch
+    })
+    if p, ok := f.result.(panicValue); ok {
+	panic(p.value)
+    }
+    return f.result
+}
+
+// An environment: a map of ["var":val] pairs, with an outer Env. mu
+// guards writes to inner, since futures let multiple goroutines
+// define/set! into a shared env concurrently.
+type Env struct {
+    inner map[string]interface{}
+    outer *Env
+    mu sync.Mutex
+}
+
+func newEmptyEnv() *Env {
+    return &Env{inner: make(map[string]interface{}, 0)}
+}
+
+// newEnv binds parms against args. parms may be a proper list, a
+// dotted list, or a bare symbol; any trailing symbol collects the
+// remaining args as a list.
+func newEnv(parms interface{}, args []interface{}, outer *Env) *Env {
+    env := newEmptyEnv()
+    env.outer = outer
+    i := 0
+    for {
+	if str, ok := isSymbol(parms); ok {
+	    env.inner[str] = list(args[i:]...)
+	    break
+	}
+	c, ok := parms.(*Cell)
+	if !ok || c == Nil {
+	    break
+	}
+	str, ok := isSymbol(c.Car)
+	if !ok {
+	    panic("parms needs symbols")
+	}
+	env.inner[str] = args[i]
+	i++
+	parms = c.Cdr
+    }
+    return env
+}
+
+// Get safely reads var from this Env alone, without checking outer.
+func (env *Env) Get(v string) interface{} {
+    env.mu.Lock()
+    defer env.mu.Unlock()
+    return env.inner[v]
+}
+
+// Find the innermost Env where var appears.
+func (env *Env) Find(v string) *Env {
+    if env.Get(v) != nil || env.outer == nil {
+	return env
+    }
+    return env.outer.Find(v)
+}
+
+// isNumber reports whether x is a Scheme number (int64 or float64).
+func isNumber(x interface{}) bool {
+    switch x.(type) {
+    case int64, float64:
+	return true
+    }
+    return false
+}
+
+// requireNumber panics with a message naming op if x is not a number.
+func requireNumber(op string, x interface{}) {
+    if !isNumber(x) {
+	panic(op + " needs numbers")
+    }
+}
+
+// promote reports whether a or b is a float64, and if so returns both as
+// float64 so the caller can do the float-promoted arithmetic.
+func promote(a, b interface{}) (float64, float64, bool) {
+    af, aIsFloat := a.(float64)
+    bf, bIsFloat := b.(float64)
+    if !aIsFloat && !bIsFloat {
+	return 0, 0, false
+    }
+    if !aIsFloat {
+	af = float64(a.(int64))
+    }
+    if !bIsFloat {
+	bf = float64(b.(int64))
+    }
+    return af, bf, true
+}
+
+func numAdd(a, b interface{}) interface{} {
+    if af, bf, ok := promote(a, b); ok {
+	return af + bf
+    }
+    return a.(int64) + b.(int64)
+}
+
+func numSub(a, b interface{}) interface{} {
+    if af, bf, ok := promote(a, b); ok {
+	return af - bf
+    }
+    return a.(int64) - b.(int64)
+}
+
+func numMul(a, b interface{}) interface{} {
+    if af, bf, ok := promote(a, b); ok {
+	return af * bf
+    }
+    return a.(int64) * b.(int64)
+}
+
+// numQuotient truncates a/b towards zero, promoting to float64 when
+// either operand is a float, the same way numAdd/numSub do.
+func numQuotient(op string, a, b interface{}) interface{} {
+    if af, bf, ok := promote(a, b); ok {
+	if bf == 0 {
+	    panic(op + " by zero")
+	}
+	return math.Trunc(af / bf)
+    }
+    ai, bi := a.(int64), b.(int64)
+    if bi == 0 {
+	panic(op + " by zero")
+    }
+    return ai / bi
+}
+
+// numRemainder returns a - b*numQuotient(a, b); the result has the sign
+// of a, like Scheme's remainder.
+func numRemainder(op string, a, b interface{}) interface{} {
+    if af, bf, ok := promote(a, b); ok {
+	if bf == 0 {
+	    panic(op + " by zero")
+	}
+	return math.Mod(af, bf)
+    }
+    ai, bi := a.(int64), b.(int64)
+    if bi == 0 {
+	panic(op + " by zero")
+    }
+    return ai % bi
+}
+
+// numMod returns a modulo b with the sign of b, like Scheme's modulo.
+func numMod(op string, a, b interface{}) interface{} {
+    if af, bf, ok := promote(a, b); ok {
+	if bf == 0 {
+	    panic(op + " by zero")
+	}
+	m := math.Mod(af, bf)
+	if m != 0 && (m < 0) != (bf < 0) {
+	    m += bf
+	}
+	return m
+    }
+    ai, bi := a.(int64), b.(int64)
+    if bi == 0 {
+	panic(op + " by zero")
+    }
+    m := ai % bi
+    if m != 0 && (m < 0) != (bi < 0) {
+	m += bi
+    }
+    return m
+}
+
+// numDiv divides a by b, staying in int64 when both operands are ints
+// and the division is exact, and promoting to float64 otherwise.
+func numDiv(a, b interface{}) interface{} {
+    if af, bf, ok := promote(a, b); ok {
+	return af / bf
+    }
+    ai, bi := a.(int64), b.(int64)
+    if bi == 0 {
+	panic("/ by zero")
+    }
+    if ai%bi == 0 {
+	return ai / bi
+    }
+    return float64(ai) / float64(bi)
+}
+
+// numCompare returns -1, 0, or 1 according to whether a is less than,
+// equal to, or greater than b.
+func numCompare(a, b interface{}) int {
+    af, bf, ok := promote(a, b)
+    if !ok {
+	ai, bi := a.(int64), b.(int64)
+	af, bf = float64(ai), float64(bi)
+    }
+    switch {
+    case af < bf:
+	return -1
+    case af > bf:
+	return 1
+    default:
+	return 0
+    }
+}
+
+// numFold reduces args onto id with op, the implementation behind the
+// variadic + and * primitives.
+func numFold(op string, id interface{}, fn func(a, b interface{}) interface{}) func([]interface{}) interface{} {
+    return func(args []interface{}) interface{} {
+	result := id
+	for _, a := range args {
+	    requireNumber(op, a)
+	    result = fn(result, a)
+	}
+	return result
+    }
+}
+
+// numReduce behaves like Scheme's -/÷: a single argument negates or
+// inverts against id, two or more are folded left to right.
+func numReduce(op string, id interface{}, fn func(a, b interface{}) interface{}) func([]interface{}) interface{} {
+    return func(args []interface{}) interface{} {
+	if len(args) == 0 {
+	    panic(op + " needs numbers")
+	}
+	for _, a := range args {
+	    requireNumber(op, a)
+	}
+	if len(args) == 1 {
+	    return fn(id, args[0])
+	}
+	result := args[0]
+	for _, a := range args[1:] {
+	    result = fn(result, a)
+	}
+	return result
+    }
+}
+
+// compareChain builds a variadic comparison primitive (=, <, >, <=, >=)
+// that checks ok holds between every pair of consecutive arguments.
+func compareChain(op string, ok func(int) bool) func([]interface{}) interface{} {
+    return func(args []interface{}) interface{} {
+	for _, a := range args {
+	    requireNumber(op, a)
+	}
+	for i := 0; i+1 < len(args); i++ {
+	    if !ok(numCompare(args[i], args[i+1])) {
+		return false
+	    }
+	}
+	return true
+    }
+}
+
+// minMax builds the min/max primitive: better reports whether candidate
+// a should replace the running result.
+func minMax(op string, better func(cmp int) bool) func([]interface{}) interface{} {
+    return func(args []interface{}) interface{} {
+	if len(args) == 0 {
+	    panic(op + " needs numbers")
+	}
+	requireNumber(op, args[0])
+	result := args[0]
+	for _, a := range args[1:] {
+	    requireNumber(op, a)
+	    if better(numCompare(a, result)) {
+		result = a
+	    }
+	}
+	return result
+    }
+}
+
+// An environment with some Scheme standard procedures.
+func standardEnv() *Env {
+    env := newEmptyEnv()
+    env.inner["false"] = false
+    env.inner["true"] = true
+    env.inner["+"] = numFold("+", int64(0), numAdd)
+    env.inner["*"] = numFold("*", int64(1), numMul)
+    env.inner["-"] = numReduce("-", int64(0), numSub)
+    env.inner["/"] = numReduce("/", int64(1), numDiv)
+    env.inner["quotient"] = func(args []interface{}) interface{} {
+	requireNumber("quotient", args[0])
+	requireNumber("quotient", args[1])
+	return numQuotient("quotient", args[0], args[1])
+    }
+    env.inner["remainder"] = func(args []interface{}) interface{} {
+	requireNumber("remainder", args[0])
+	requireNumber("remainder", args[1])
+	return numRemainder("remainder", args[0], args[1])
+    }
+    env.inner["mod"] = func(args []interface{}) interface{} {
+	requireNumber("mod", args[0])
+	requireNumber("mod", args[1])
+	return numMod("mod", args[0], args[1])
+    }
+    env.inner["="] = compareChain("=", func(c int) bool { return c == 0 })
+    env.inner["<"] = compareChain("<", func(c int) bool { return c < 0 })
+    env.inner[">"] = compareChain(">", func(c int) bool { return c > 0 })
+    env.inner["<="] = compareChain("<=", func(c int) bool { return c <= 0 })
+    env.inner[">="] = compareChain(">=", func(c int) bool { return c >= 0 })
+    env.inner["number?"] = func(args []interface{}) interface{} {
+	return isNumber(args[0])
+    }
+    env.inner["integer?"] = func(args []interface{}) interface{} {
+	_, ok := args[0].(int64)
+	return ok
+    }
+    env.inner["zero?"] = func(args []interface{}) interface{} {
+	requireNumber("zero?", args[0])
+	return numCompare(args[0], int64(0)) == 0
+    }
+    env.inner["positive?"] = func(args []interface{}) interface{} {
+	requireNumber("positive?", args[0])
+	return numCompare(args[0], int64(0)) > 0
+    }
+    env.inner["negative?"] = func(args []interface{}) interface{} {
+	requireNumber("negative?", args[0])
+	return numCompare(args[0], int64(0)) < 0
+    }
+    env.inner["abs"] = func(args []interface{}) interface{} {
+	requireNumber("abs", args[0])
+	if f, ok := args[0].(float64); ok {
+	    if f < 0 {
+		return -f
+	    }
+	    return f
+	}
+	n := args[0].(int64)
+	if n < 0 {
+	    return -n
+	}
+	return n
+    }
+    env.inner["min"] = minMax("min", func(c int) bool { return c < 0 })
+    env.inner["max"] = minMax("max", func(c int) bool { return c > 0 })
+    env.inner["cons"] = func(args []interface{}) interface{} {
+	return &Cell{args[0], args[1]}
+    }
+    env.inner["car"] = func(args []interface{}) interface{} {
+	c, ok := args[0].(*Cell)
+	if !ok || c == Nil {
+	    panic("car needs a pair")
+	}
+	return c.Car
+    }
+    env.inner["cdr"] = func(args []interface{}) interface{} {
+	c, ok := args[0].(*Cell)
+	if !ok || c == Nil {
+	    panic("cdr needs a pair")
+	}
+	return c.Cdr
+    }
+    env.inner["pair?"] = func(args []interface{}) interface{} {
+	c, ok := args[0].(*Cell)
+	return ok && c != Nil
+    }
+    env.inner["null?"] = func(args []interface{}) interface{} {
+	c, ok := args[0].(*Cell)
+	return ok && c == Nil
+    }
+    env.inner["list"] = func(args []interface{}) interface{} {
+	return list(args...)
+    }
+    env.inner["eq?"] = func(args []interface{}) interface{} {
+	return args[0] == args[1]
+    }
+    return env
+}
+
+var globalEnv = standardEnv()
+
+// rest asserts that x is a *Cell and returns it, as used when walking
+// the tail of a special-form expression.
+func rest(x interface{}) *Cell {
+    c, ok := x.(*Cell)
+    if !ok {
+	panic("ill-formed special form")
+    }
+    return c
+}
+
+func cadr(c *Cell) interface{} {
+    return rest(c.Cdr).Car
+}
+
+func caddr(c *Cell) interface{} {
+    return rest(rest(c.Cdr).Cdr).Car
+}
+
+func cadddr(c *Cell) interface{} {
+    return rest(rest(rest(c.Cdr).Cdr).Cdr).Car
+}
+
+// Evaluate an expression in an environment.
+// eval is written as a trampoline: tail positions rebind x/env and
+// continue the loop instead of recursing, so a tail call never grows
+// the Go stack. Non-tail sub-expressions still call eval normally.
+func eval(x interface{}, env *Env) interface{} {
+    for {
+	if str, ok := isSymbol(x); ok { // variable reference
+	    return env.Find(str).Get(str)
+	}
+	c, ok := isPair(x)
+	if !ok { // constant literal
+	    return x
+	}
+	if c == Nil {
+	    panic("empty list")
+	}
+	if str, ok := isSymbol(c.Car); ok {
+	    switch (str) {
+	    case "quote":
+		// (quote exp)
+		return cadr(c)
+	    case "if":
+		//  (if test conseq alt), tail position is conseq/alt
+		test, conseq, alt := cadr(c), caddr(c), cadddr(c)
+		r := eval(test, env)
+		if b, ok := isFalse(r); ok && b {
+		    x = alt
+		} else {
+		    x = conseq
+		}
+		continue
+	    case "define":
+		// (define var exp)
+		v, exp := cadr(c), caddr(c)
+		if str, ok = isSymbol(v); ok {
+		    val := eval(exp, env)
+		    env.mu.Lock()
+		    env.inner[str] = val
+		    env.mu.Unlock()
+		    return val
+		} else {
+		    panic("define needs a symbol")
+		}
+	    case "set!":
+		// (set! var exp)
+		v, exp := cadr(c), caddr(c)
+		if str, ok := isSymbol(v); ok {
+		    e := env.Find(str)
+		    val := eval(exp, env)
+		    e.mu.Lock()
+		    e.inner[str] = val
+		    e.mu.Unlock()
+		    return val
+		} else {
+		    panic("set! needs a symbol")
+		}
+	    case "lambda":
+		// (lambda parms body)
+		parms, body := cadr(c), caddr(c)
+		return newProcedure(parms, body, env)
+	    case "defmacro":
+		// (defmacro name parms body)
+		name, parms, body := cadr(c), caddr(c), cadddr(c)
+		if str, ok = isSymbol(name); ok {
+		    m := newMacro(parms, body, env)
+		    env.mu.Lock()
+		    env.inner[str] = m
+		    env.mu.Unlock()
+		    return m
+		} else {
+		    panic("defmacro needs a symbol")
+		}
+	    case "quasiquote":
+		// (quasiquote template)
+		return quasiquote(cadr(c), env)
+	    case "future":
+		// (future exp) - evaluate exp in a new goroutine, return a handle
+		return newFuture(cadr(c), env)
+	    case "force":
+		// (force exp) - block for a future's result; non-futures pass through
+		v := eval(cadr(c), env)
+		if f, ok := v.(*Future); ok {
+		    return f.Force()
+		}
+		return v
+	    case "begin":
+		// (begin exp...), tail position is the last exp
+		last, ok := tailBody(rest(c.Cdr), env)
+		if !ok {
+		    return nil
+		}
+		x = last
+		continue
+	    case "cond":
+		// (cond (test exp...) ... (else exp...)), tail position is the
+		// last exp of the matching clause
+		body := Nil
+		clause := rest(c.Cdr)
+		for clause != Nil {
+		    cl := rest(clause.Car)
+		    if sym, ok := isSymbol(cl.Car); ok && sym == "else" {
+			body = rest(cl.Cdr)
+			break
+		    }
+		    if truthy(eval(cl.Car, env)) {
+			body = rest(cl.Cdr)
+			break
+		    }
+		    clause = rest(clause.Cdr)
+		}
+		last, ok := tailBody(body, env)
+		if !ok {
+		    return nil
+		}
+		x = last
+		continue
+	    case "case":
+		// (case key (datums exp...) ... (else exp...)), tail position is
+		// the last exp of the matching clause
+		key := eval(cadr(c), env)
+		body := Nil
+		clause := rest(rest(c.Cdr).Cdr)
+	    findClause:
+		for clause != Nil {
+		    cl := rest(clause.Car)
+		    if sym, ok := isSymbol(cl.Car); ok && sym == "else" {
+			body = rest(cl.Cdr)
+			break
+		    }
+		    datums := rest(cl.Car)
+		    for datums != Nil {
+			if datums.Car == key {
+			    body = rest(cl.Cdr)
+			    break findClause
+			}
+			datums = rest(datums.Cdr)
+		    }
+		    clause = rest(clause.Cdr)
+		}
+		last, ok := tailBody(body, env)
+		if !ok {
+		    return nil
+		}
+		x = last
+		continue
+	    case "let":
+		// (let ((var val)...) body...), tail position is the last exp
+		letEnv := newEmptyEnv()
+		letEnv.outer = env
+		bindings := rest(cadr(c))
+		for bindings != Nil {
+		    binding := rest(bindings.Car)
+		    sym, ok := isSymbol(binding.Car)
+		    if !ok {
+			panic("let needs symbols")
+		    }
+		    letEnv.inner[sym] = eval(cadr(binding), env)
+		    bindings = rest(bindings.Cdr)
+		}
+		last, ok := tailBody(rest(rest(c.Cdr).Cdr), letEnv)
+		if !ok {
+		    return nil
+		}
+		x, env = last, letEnv
+		continue
+	    case "let*":
+		// (let* ((var val)...) body...), each binding sees the ones
+		// before it; tail position is the last exp
+		letEnv := env
+		bindings := rest(cadr(c))
+		for bindings != Nil {
+		    binding := rest(bindings.Car)
+		    sym, ok := isSymbol(binding.Car)
+		    if !ok {
+			panic("let* needs symbols")
+		    }
+		    next := newEmptyEnv()
+		    next.outer = letEnv
+		    next.inner[sym] = eval(cadr(binding), letEnv)
+		    letEnv = next
+		    bindings = rest(bindings.Cdr)
+		}
+		last, ok := tailBody(rest(rest(c.Cdr).Cdr), letEnv)
+		if !ok {
+		    return nil
+		}
+		x, env = last, letEnv
+		continue
+	    case "and":
+		// (and exp...), short-circuiting on the first false; tail
+		// position is the last exp
+		clause := rest(c.Cdr)
+		if clause == Nil {
+		    return true
+		}
+		for rest(clause.Cdr) != Nil {
+		    result := eval(clause.Car, env)
+		    if !truthy(result) {
+			return result
+		    }
+		    clause = rest(clause.Cdr)
+		}
+		x = clause.Car
+		continue
+	    case "or":
+		// (or exp...), short-circuiting on the first truthy value;
+		// tail position is the last exp
+		clause := rest(c.Cdr)
+		if clause == Nil {
+		    return false
+		}
+		for rest(clause.Cdr) != Nil {
+		    result := eval(clause.Car, env)
+		    if truthy(result) {
+			return result
+		    }
+		    clause = rest(clause.Cdr)
+		}
+		x = clause.Car
+		continue
+	    }
+	}
+	// (proc arg...)
+	car := eval(c.Car, env)
+	if m, ok := car.(*Macro); ok {
+	    x = m.Expand(rest(c.Cdr))
+	    continue
+	}
+	args := makeArgs(rest(c.Cdr), env)
+	if prim, ok := isPrim(car); ok {
+	    return prim(args)
+	}
+	if proc, ok := car.(*Procedure); ok {
+	    // tail call: inline Procedure.Call instead of recursing
+	    x, env = proc.body, newEnv(proc.parms, args, proc.env)
+	    continue
+	}
+	return nil
+    }
+}
+
+func makeArgs(c *Cell, env *Env) []interface{} {
+    args := make([]interface{}, 0)
+    for c != Nil {
+	args = append(args, eval(c.Car, env))
+	c = rest(c.Cdr)
+    }
+    return args
+}
+
+func isSymbol(x interface{}) (string, bool) {
+    s, ok := x.(string)
+    return s, ok
+}
+
+func isPair(x interface{}) (*Cell, bool) {
+    c, ok := x.(*Cell)
+    return c, ok
+}
+
+func isFalse(x interface{}) (bool, bool) {
+    b, ok := x.(bool)
+    return !b, ok
+}
+
+// truthy reports whether x counts as true in a test position; everything
+// but the boolean false is truthy.
+func truthy(x interface{}) bool {
+    b, ok := isFalse(x)
+    return !(ok && b)
+}
+
+// tailBody evaluates all but the last expression of a body (a begin,
+// let, or cond/case clause) for effect, then returns the last one
+// unevaluated so the caller can tail-evaluate it. ok is false for an
+// empty body.
+func tailBody(c *Cell, env *Env) (interface{}, bool) {
+    if c == Nil {
+	return nil, false
+    }
+    for rest(c.Cdr) != Nil {
+	eval(c.Car, env)
+	c = rest(c.Cdr)
+    }
+    return c.Car, true
+}
+
+// quasiquote walks a quasiquote template, evaluating ,x at unquote
+// sites and splicing in the list produced by ,@x.
+func quasiquote(x interface{}, env *Env) interface{} {
+    c, ok := x.(*Cell)
+    if !ok || c == Nil {
+	return x
+    }
+    if sym, ok := isSymbol(c.Car); ok && sym == "unquote" {
+	return eval(cadr(c), env)
+    }
+    if head, ok := c.Car.(*Cell); ok && head != Nil {
+	if sym, ok := isSymbol(head.Car); ok && sym == "unquote-splicing" {
+	    spliced := eval(cadr(head), env)
+	    return appendAny(spliced, quasiquote(c.Cdr, env))
+	}
+    }
+    return &Cell{quasiquote(c.Car, env), quasiquote(c.Cdr, env)}
+}
+
+// appendAny appends the proper list l onto tail, which may itself be a
+// non-list value for the dotted-tail case.
+func appendAny(l interface{}, tail interface{}) interface{} {
+    c, ok := l.(*Cell)
+    if !ok || c == Nil {
+	return tail
+    }
+    return &Cell{c.Car, appendAny(c.Cdr, tail)}
+}
+
+func isPrim(x interface{}) (func([]interface{})interface{}, bool) {
+    prim, ok := x.(func([]interface{})interface{})
+    return prim, ok
+}
+
+// A prompt-read-eval-print loop.
+func repl() {
+    prompt := "lis.go> "
+    in := bufio.NewReader(os.Stdin)
+    for {
+	fmt.Printf("%s", prompt)
+	line, _ := in.ReadString('\n')
+	val := eval(parse(line), globalEnv)
+        if val != nil {
+            fmt.Printf("%s\n", schemestr(val))
+	}
+    }
+}
+
+// Convert a Python object back into a Scheme-readable string.
+func schemestr(exp interface{}) string {
+    if c, ok := isPair(exp); ok {
+	if c == Nil {
+	    return "()"
+	}
+	s := make([]string, 0)
+	for c != Nil {
+	    s = append(s, schemestr(c.Car))
+	    next, ok := c.Cdr.(*Cell)
+	    if !ok {
+		s = append(s, ".", schemestr(c.Cdr))
+		break
+	    }
+	    c = next
+	}
+        return "(" + strings.Join(s, " ") + ")"
+    } else if _, ok := exp.(*Future); ok {
+	return "#<future>"
+    } else if n, ok := exp.(int64); ok {
+	return fmt.Sprintf("%d", n)
+    } else if f, ok := exp.(float64); ok {
+	return formatFloat(f)
+    } else {
+        return fmt.Sprintf("%v", exp)
+    }
+}
+
+// formatFloat renders f the way Scheme numbers print, always keeping a
+// decimal point or exponent so floats are never confused with ints.
+func formatFloat(f float64) string {
+    s := strconv.FormatFloat(f, 'g', -1, 64)
+    if !strings.ContainsAny(s, ".eE") {
+	s += ".0"
+    }
+    return s
+}
+
+func dump(s string) {
+    d(parse(s))
+}
+
+func d(a interface{}) {
+    fmt.Printf("%s\n", a)
+}
+
+func e(s string) {
+    d(eval(parse(s), globalEnv))
+}
+
+func t() {
+    dump("1")
+    dump("a")
+    dump("()")
+    dump("(1)")
+    dump("(1 2)")
+    dump("(1 (2))")
+    dump("(1 . 2)")
+    dump("(1 2 . 3)")
+    str, ok := isSymbol("a")
+    fmt.Printf("%s %s\n", str, ok)
+    str, ok = isSymbol(1)
+    fmt.Printf("%s %s\n", str, ok)
+    e("(quote (b))")
+    e("(if false 1 2)")
+    e("(if true 1 2)")
+    e("(define a 10)")
+    e("a")
+    e("(+ 1 2)")
+    e("(set! a 20)")
+    e("a")
+    e("(define x2 (lambda (a) (+ a a)))")
+    e("(x2 100)")
+    e("(cons 1 2)")
+    e("(car (cons 1 2))")
+    e("(cdr (cons 1 2))")
+    e("(pair? (cons 1 2))")
+    e("(null? (quote ()))")
+    e("(list 1 2 3)")
+    e("(eq? 1 1)")
+    e("(define f (lambda args args))")
+    e("(f 1 2 3)")
+    e("(define g (lambda (a . rest) rest))")
+    e("(g 1 2 3)")
+    e("(begin 1 2 3)")
+    e("(cond (false 1) (else 2))")
+    e("(cond (true 1) (else 2))")
+    e("(case 2 ((1) (quote one)) ((2) (quote two)) (else (quote other)))")
+    e("(case 9 ((1) (quote one)) (else (quote other)))")
+    e("(let ((x 1) (y 2)) (+ x y))")
+    e("(let* ((x 1) (y (+ x 1)) (z (+ x y))) (let* ((w (+ z 1))) w))")
+    e("(and)")
+    e("(or)")
+    e("(and true true)")
+    e("(and true false 1)")
+    e("(or false false)")
+    e("(or false 1 2)")
+    e("(defmacro my-if (c t f) (list (quote cond) (list c t) (list (quote else) f)))")
+    e("(my-if true 1 2)")
+    e("(my-if false 1 2)")
+    e("'foo")
+    e("'(1 2 3)")
+    e("(quasiquote (1 2 3))")
+    e("`(a ,(+ 1 2) b)")
+    e("(define lst (list 2 3))")
+    e("`(1 ,@lst 4)")
+    e("(define fut (future (+ 1 2)))")
+    e("(force fut)")
+    e("(force 5)")
+    // Regression test for tail-call optimization: a self-recursive loop
+    // of 1,000,000 iterations must not grow the Go stack. The countdown
+    // is driven by cdr/null? over a long list rather than decrementing
+    // an integer, so it also exercises the trampoline independently of
+    // the arithmetic primitives below.
+    globalEnv.inner["biglist"] = makeCountList(1000000)
+    e("(define count-down (lambda (lst) (if (null? lst) (quote done) (count-down (cdr lst)))))")
+    e("(count-down biglist)")
+    e("(+ 1 2 3)")
+    e("(- 10 1 2)")
+    e("(- 5)")
+    e("(* 2 3 4)")
+    e("(/ 10 2)")
+    e("(/ 1 3)")
+    e("(/ 2)")
+    e("(+ 1 2.5)")
+    e("1.5")
+    e("1e3")
+    e("(quotient 7 2)")
+    e("(remainder 7 2)")
+    e("(remainder -7 2)")
+    e("(mod -7 2)")
+    e("(quotient 7.0 2)")
+    e("(remainder 7.5 2)")
+    e("(mod 7.5 2)")
+    e("(mod -7.5 2)")
+    e("(= 1 1 1)")
+    e("(< 1 2 3)")
+    e("(< 1 3 2)")
+    e("(number? 1)")
+    e("(number? (quote a))")
+    e("(integer? 1)")
+    e("(integer? 1.5)")
+    e("(zero? 0)")
+    e("(positive? 1)")
+    e("(negative? -1)")
+    e("(abs -5)")
+    e("(min 3 1 2)")
+    e("(max 3 1 2)")
+}
+
+// makeCountList builds a proper list of n elements, used to drive the
+// tail-call regression test above without recursing in Go.
+func makeCountList(n int) *Cell {
+    result := Nil
+    for i := 0; i < n; i++ {
+	result = &Cell{i, result}
+    }
+    return result
+}
+
+func main() {
+    repl()
+}